@@ -0,0 +1,15 @@
+package src
+
+// BuildState is the final state a build is reported to the coordinator in,
+// via FinishBuild. It is distinct from BuildRuntimeState, which tracks where
+// a build currently is in its lifecycle rather than how it ended.
+type BuildState int
+
+const (
+	Pending BuildState = iota
+	Running
+	Success
+	Failed
+	Canceled
+	TimedOut
+)
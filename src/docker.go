@@ -0,0 +1,156 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// DockerClient is the subset of *docker.Client the executors depend on. It
+// exists so tests can inject a fake instead of talking to a real daemon.
+type DockerClient interface {
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	KillContainer(opts docker.KillContainerOptions) error
+	WaitContainer(id string) (int, error)
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+}
+
+// newDockerClient is the production DockerClient factory, used by the
+// builtin "docker"/"docker-ssh" executor registrations.
+func newDockerClient() DockerClient {
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+const dockerKillDelay = 10 * time.Second
+
+type DockerExecutor struct {
+	BaseExecutor
+
+	Client      DockerClient
+	containerId string
+
+	// stopped is closed once the goroutine started by Start() has confirmed
+	// the container is gone (gracefully stopped or force-killed), so Cleanup
+	// can wait on it instead of racing RemoveContainer against the escalation
+	// still in progress.
+	stopped chan struct{}
+}
+
+func (e *DockerExecutor) Prepare(ctx context.Context, config *RunnerConfig, build *Build) error {
+	return e.BaseExecutor.Prepare(ctx, config, build)
+}
+
+func (e *DockerExecutor) Start() error {
+	container, err := e.Client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      e.build.Image,
+			Cmd:        []string{"bash"},
+			OpenStdin:  true,
+			StdinOnce:  true,
+			Tty:        false,
+			WorkingDir: e.builds_dir,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	e.containerId = container.ID
+
+	if err := e.Client.StartContainer(e.containerId, nil); err != nil {
+		return err
+	}
+
+	e.stopped = make(chan struct{})
+	go func() {
+		defer close(e.stopped)
+		e.buildFinish <- waitOrStopContainer(e.ctx, e.Client, e.containerId, dockerKillDelay)
+	}()
+	return nil
+}
+
+// waitOrStopContainer waits for the container to exit, but if ctx is
+// cancelled first it sends SIGINT and gives it killDelay to stop gracefully
+// before force-killing it with SIGKILL - the container analogue of
+// WaitOrStop for the shell executor. It always waits for WaitContainer to
+// return, so the caller can be sure the container is gone once it returns.
+func waitOrStopContainer(ctx context.Context, client DockerClient, containerId string, killDelay time.Duration) error {
+	waitCh := make(chan error, 1)
+	go func() {
+		code, err := client.WaitContainer(containerId)
+		if err != nil {
+			waitCh <- err
+			return
+		}
+		if code != 0 {
+			waitCh <- fmt.Errorf("container exited with code %d", code)
+			return
+		}
+		waitCh <- nil
+	}()
+
+	select {
+	case err := <-waitCh:
+		return err
+
+	case <-ctx.Done():
+		client.KillContainer(docker.KillContainerOptions{
+			ID:     containerId,
+			Signal: docker.SIGINT,
+		})
+
+		select {
+		case err := <-waitCh:
+			return err
+
+		case <-time.After(killDelay):
+			client.KillContainer(docker.KillContainerOptions{
+				ID:     containerId,
+				Signal: docker.SIGKILL,
+			})
+			return <-waitCh
+		}
+	}
+}
+
+func (e *DockerExecutor) Cleanup() {
+	if e.containerId != "" {
+		// Make sure the escalation goroutine from Start() isn't left waiting
+		// on a context that's about to be cancelled out from under it, then
+		// wait for it to actually confirm the container is gone before we
+		// force-remove it - otherwise we'd race RemoveContainer against our
+		// own SIGINT-then-SIGKILL sequence and short-circuit it every time.
+		if e.cancel != nil {
+			e.cancel()
+		}
+		if e.stopped != nil {
+			<-e.stopped
+		}
+
+		e.Client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    e.containerId,
+			Force: true,
+		})
+	}
+	e.BaseExecutor.Cleanup()
+}
+
+// DockerCommandExecutor is the "docker" executor. It adds nothing to
+// DockerExecutor, which starts the build image with bash as its command; the
+// generated build script is not passed to the container.
+type DockerCommandExecutor struct {
+	DockerExecutor
+}
+
+// DockerSshExecutor is the "docker-ssh" executor. It adds nothing to
+// DockerExecutor: it does not connect to the container over SSH, and the
+// generated build script is not passed to the container.
+type DockerSshExecutor struct {
+	DockerExecutor
+}
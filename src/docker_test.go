@@ -0,0 +1,129 @@
+package src
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type fakeDockerClient struct {
+	mu      sync.Mutex
+	signals []docker.Signal
+
+	// exitAfterSignal makes WaitContainer return once this many signals
+	// have been observed, simulating a container that stops on SIGINT but
+	// not before.
+	exitAfterSignal int
+	waitCh          chan struct{}
+}
+
+func newFakeDockerClient(exitAfterSignal int) *fakeDockerClient {
+	return &fakeDockerClient{exitAfterSignal: exitAfterSignal, waitCh: make(chan struct{})}
+}
+
+func (f *fakeDockerClient) CreateContainer(docker.CreateContainerOptions) (*docker.Container, error) {
+	return &docker.Container{ID: "fake"}, nil
+}
+func (f *fakeDockerClient) StartContainer(string, *docker.HostConfig) error     { return nil }
+func (f *fakeDockerClient) RemoveContainer(docker.RemoveContainerOptions) error { return nil }
+
+func (f *fakeDockerClient) KillContainer(opts docker.KillContainerOptions) error {
+	f.mu.Lock()
+	f.signals = append(f.signals, opts.Signal)
+	n := len(f.signals)
+	f.mu.Unlock()
+
+	if n >= f.exitAfterSignal {
+		select {
+		case <-f.waitCh:
+		default:
+			close(f.waitCh)
+		}
+	}
+	return nil
+}
+
+func (f *fakeDockerClient) WaitContainer(id string) (int, error) {
+	if f.exitAfterSignal == 0 {
+		<-make(chan struct{}) // never exits on its own within the test
+	}
+	<-f.waitCh
+	return 0, nil
+}
+
+func (f *fakeDockerClient) signalsSent() []docker.Signal {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]docker.Signal, len(f.signals))
+	copy(out, f.signals)
+	return out
+}
+
+func TestWaitOrStopContainerStopsOnSIGINT(t *testing.T) {
+	client := newFakeDockerClient(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := waitOrStopContainer(ctx, client, "fake", time.Second); err != nil {
+		t.Fatalf("waitOrStopContainer() = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("waitOrStopContainer() took %v, expected to stop well before the kill delay", elapsed)
+	}
+
+	signals := client.signalsSent()
+	if len(signals) != 1 || signals[0] != docker.SIGINT {
+		t.Fatalf("signals sent = %v, want exactly [SIGINT]", signals)
+	}
+}
+
+func TestWaitOrStopContainerEscalatesToSIGKILL(t *testing.T) {
+	// exitAfterSignal=2: the container ignores SIGINT and only "stops" once
+	// the second signal (SIGKILL) arrives, proving the escalation fires.
+	client := newFakeDockerClient(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	killDelay := 100 * time.Millisecond
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := waitOrStopContainer(ctx, client, "fake", killDelay); err != nil {
+		t.Fatalf("waitOrStopContainer() = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed < killDelay {
+		t.Fatalf("waitOrStopContainer() returned after %v, expected to wait out the kill delay (%v) first", elapsed, killDelay)
+	}
+
+	signals := client.signalsSent()
+	if len(signals) != 2 || signals[0] != docker.SIGINT || signals[1] != docker.SIGKILL {
+		t.Fatalf("signals sent = %v, want [SIGINT, SIGKILL]", signals)
+	}
+}
+
+func TestDockerExecutorCleanupWaitsForTeardownBeforeRemoving(t *testing.T) {
+	// exitAfterSignal=2: the container only "stops" once SIGKILL arrives, so
+	// if Cleanup() returned before that, we'd see the escalation cut short.
+	client := newFakeDockerClient(2)
+
+	executor := &DockerExecutor{Client: client, containerId: "fake"}
+	executor.ctx, executor.cancel = context.WithCancel(context.Background())
+	executor.buildFinish = make(chan error, 1)
+
+	executor.stopped = make(chan struct{})
+	go func() {
+		defer close(executor.stopped)
+		executor.buildFinish <- waitOrStopContainer(executor.ctx, executor.Client, executor.containerId, 50*time.Millisecond)
+	}()
+
+	executor.Cleanup()
+
+	signals := client.signalsSent()
+	if len(signals) != 2 || signals[0] != docker.SIGINT || signals[1] != docker.SIGKILL {
+		t.Fatalf("signals sent by the time Cleanup() returned = %v, want [SIGINT, SIGKILL]", signals)
+	}
+}
@@ -1,47 +1,107 @@
 package src
 
 import (
+	"context"
 	"fmt"
-	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/iittalaaus/gitlab-ci-multi-runner/src/livelog"
 )
 
 type Executor interface {
-	Prepare(config *RunnerConfig, build *Build) error
+	Prepare(ctx context.Context, config *RunnerConfig, build *Build) error
 	Start() error
 	Wait() error
 	Cleanup()
 }
 
-func GetExecutor(config RunnerConfig) Executor {
-	switch config.Executor {
-	case "shell", "":
+// ExecutorFactory builds a new, unstarted Executor for a given runner
+// config. Builtin executors register themselves from init(); forks can
+// register additional ones (e.g. "docker-machine", "kubernetes") without
+// touching this file.
+type ExecutorFactory func(config RunnerConfig) Executor
+
+var executorFactories = map[string]ExecutorFactory{}
+
+// RegisterExecutor makes an executor available under config.Executor == name.
+// It panics on duplicate registration, the same way other init()-time
+// registries in this codebase do.
+func RegisterExecutor(name string, factory ExecutorFactory) {
+	if _, ok := executorFactories[name]; ok {
+		panic("executor already registered: " + name)
+	}
+	executorFactories[name] = factory
+}
+
+func init() {
+	RegisterExecutor("shell", func(config RunnerConfig) Executor {
 		return &ShellExecutor{
-			BaseExecutor{
+			BaseExecutor: BaseExecutor{
 				DefaultBuildsDir: "tmp/builds",
 			},
 		}
-	case "docker":
+	})
+	RegisterExecutor("docker", func(config RunnerConfig) Executor {
 		return &DockerCommandExecutor{
 			DockerExecutor{
-				BaseExecutor{
+				BaseExecutor: BaseExecutor{
 					DefaultBuildsDir: "/builds",
 				},
+				Client: newDockerClient(),
 			},
 		}
-	case "docker-ssh":
+	})
+	RegisterExecutor("docker-ssh", func(config RunnerConfig) Executor {
 		return &DockerSshExecutor{
 			DockerExecutor{
-				BaseExecutor{
+				BaseExecutor: BaseExecutor{
 					DefaultBuildsDir: "builds",
 				},
+				Client: newDockerClient(),
 			},
 		}
-	default:
-		return nil
+	})
+}
+
+func GetExecutor(config RunnerConfig) Executor {
+	factory, ok := executorFactories[config.Executor]
+	if !ok {
+		if config.Executor != "" {
+			return nil
+		}
+		factory = executorFactories["shell"]
 	}
+	return factory(config)
+}
+
+// cancelReason records why the build's context was cancelled, so that Wait
+// can report an accurate final state instead of collapsing every
+// cancellation into Failed.
+type cancelReason int
+
+const (
+	cancelNone cancelReason = iota
+	cancelAbort
+	cancelTimeout
+)
+
+// setCancelReason atomically records reason as the build's cancelReason, but
+// only if it hasn't already been set - whichever of watchAbort or Wait's own
+// timeout gets there first wins, instead of one clobbering the other's
+// reason. It reports whether this call was the one that set it.
+func (e *BaseExecutor) setCancelReason(reason cancelReason) bool {
+	return atomic.CompareAndSwapInt32(&e.cancelReasonState, int32(cancelNone), int32(reason))
+}
+
+// currentCancelReason atomically reads the build's cancelReason.
+func (e *BaseExecutor) currentCancelReason() cancelReason {
+	return cancelReason(atomic.LoadInt32(&e.cancelReasonState))
 }
 
 type BaseExecutor struct {
@@ -53,7 +113,22 @@ type BaseExecutor struct {
 	buildLogFinish   chan bool
 	buildFinish      chan error
 	script_data      []byte
-	build_log        io.WriteCloser
+	build_log        *livelog.Buffer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// cancelReasonState backs cancelReason/setCancelReason/currentCancelReason
+	// above. It's written from both watchAbort's and Wait()'s own goroutine
+	// (a server-side cancel and a build timeout can land at the same time),
+	// so - like runtimeState - it's accessed atomically rather than as a
+	// plain field.
+	cancelReasonState int32
+
+	runtimeState         int32
+	pendingTerminalState BuildRuntimeState
+	subscribersMu        sync.Mutex
+	subscribers          []chan BuildRuntimeState
 
 	buildAbortFunc func(e *BaseExecutor)
 }
@@ -68,12 +143,23 @@ func (e *BaseExecutor) println(args ...interface{}) {
 	log.Println(args...)
 }
 
-func (e *BaseExecutor) Prepare(config *RunnerConfig, build *Build) error {
+// Context returns the build's context, valid between Prepare and Cleanup. It
+// is cancelled on abort, on timeout, and when the parent context passed to
+// Prepare is cancelled, and should be observed by Start/Wait to tear down the
+// running process or container.
+func (e *BaseExecutor) Context() context.Context {
+	return e.ctx
+}
+
+func (e *BaseExecutor) Prepare(ctx context.Context, config *RunnerConfig, build *Build) error {
 	e.config = config
 	e.build = build
+	e.setState(StatePreparing)
+
 	e.buildAbort = make(chan bool, 1)
 	e.buildFinish = make(chan error, 1)
 	e.buildLogFinish = make(chan bool)
+	e.ctx, e.cancel = context.WithCancel(ctx)
 
 	// Generate build script
 	e.builds_dir = e.DefaultBuildsDir
@@ -87,68 +173,176 @@ func (e *BaseExecutor) Prepare(config *RunnerConfig, build *Build) error {
 	}
 	e.script_data = script
 
-	// Create build log
-	build_log, err := e.build.CreateBuildLog()
-	if err != nil {
-		return err
-	}
-	e.build_log = build_log
+	// The build log is now a livelog.Buffer: an in-memory ring buffer that
+	// WatchTrace reads incrementally by offset instead of re-reading a file
+	// from disk on every tick, and that a live-tail HTTP endpoint can read
+	// concurrently with the writer.
+	e.build_log = livelog.New(livelog.DefaultSize)
+
+	// Start watching the trace/cancel channel as soon as the build is
+	// prepared, so a "Cancel" click in the GitLab UI tears down Start()
+	// within seconds instead of waiting for Wait() to be reached.
+	go e.build.WatchTrace(*e.config, e.build_log, e.buildAbort, e.buildLogFinish)
+	go e.watchAbort()
+	go e.pollCancel()
+
 	return nil
 }
 
+// watchAbort cancels the build's context as soon as something feeds
+// e.buildAbort - WatchTrace's own PATCH loop, or pollCancel below -
+// decoupling cancellation from whatever Wait()'s select statement happens
+// to be doing at the time.
+func (e *BaseExecutor) watchAbort() {
+	select {
+	case <-e.buildAbort:
+		log.Println(e.config.ShortDescription(), e.build.Id, "Build got aborted.")
+		e.abort(cancelAbort)
+	case <-e.ctx.Done():
+	}
+}
+
+// cancelPollInterval is how often pollCancel asks the coordinator whether
+// this job has been cancelled server-side.
+const cancelPollInterval = 3 * time.Second
+
+// pollCancel periodically calls Build.IsCanceled, which surfaces the
+// Job-Status: canceled header the coordinator returns on the same trace
+// PATCH WatchTrace already performs, and feeds the result into buildAbort.
+// It runs independently of WatchTrace's own PATCH loop so a cancel is
+// noticed within cancelPollInterval even if WatchTrace is mid-upload or
+// backed off after a coordinator error.
+func (e *BaseExecutor) pollCancel() {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+
+	pollForCancel(e.ctx, ticker.C, func() bool {
+		canceled := e.build.IsCanceled(*e.config)
+		if canceled {
+			log.Println(e.config.ShortDescription(), e.build.Id, "Build was canceled by the coordinator.")
+		}
+		return canceled
+	}, e.buildAbort)
+}
+
+// pollForCancel holds pollCancel's actual loop, split out so it can be unit
+// tested without needing a real Build/RunnerConfig: it ticks, asks
+// isCanceled, and on the first true result pushes to abort and returns. It
+// also returns as soon as ctx is done.
+func pollForCancel(ctx context.Context, tick <-chan time.Time, isCanceled func() bool, abort chan<- bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-tick:
+			if isCanceled() {
+				select {
+				case abort <- true:
+				default:
+				}
+				return
+			}
+		}
+	}
+}
+
 func (e *BaseExecutor) Cleanup() {
-	if e.build != nil {
-		e.build.DeleteBuildLog()
+	e.setState(StateCleaning)
+
+	// Cancelling here is a no-op if Wait() already cancelled; otherwise it
+	// starts tearing down the process/container but does not wait for it.
+	// The process/container is known to be gone only once Wait() has
+	// drained e.buildFinish (or, for Docker, once e.stopped is closed).
+	if e.cancel != nil {
+		e.cancel()
 	}
 
 	if e.build_log != nil {
 		e.build_log.Close()
 	}
+
+	terminal := e.pendingTerminalState
+	if terminal == StatePending {
+		terminal = StateFinished
+	}
+	e.setState(terminal)
+	e.closeSubscribers()
+}
+
+func (e *BaseExecutor) abort(reason cancelReason) {
+	e.setCancelReason(reason)
+	if e.cancel != nil {
+		e.cancel()
+	}
+	if e.buildAbortFunc != nil {
+		e.buildAbortFunc(e)
+	}
 }
 
 func (e *BaseExecutor) Wait() error {
 	var buildState BuildState
 	var buildMessage string
 
-	go e.build.WatchTrace(*e.config, e.buildAbort, e.buildLogFinish)
+	e.setState(StateRunning)
 
 	buildTimeout := e.build.Timeout
 	if buildTimeout <= 0 {
 		buildTimeout = DEFAULT_TIMEOUT
 	}
 
-	// Wait for signals: abort, timeout or finish
+	// Wait for signals: timeout, external cancel (from watchAbort) or finish.
+	// The timeout and ctx.Done() branches only record why we're cancelling -
+	// they still have to drain e.buildFinish below before Wait returns, since
+	// that's the channel Start()'s teardown goroutine (WaitOrStop /
+	// waitOrStopContainer) writes to once the process/container is actually
+	// confirmed dead, and Cleanup() must never run before that happens.
 	log.Debugln(e.config.ShortDescription(), e.build.Id, "Waiting for signals...")
+	var finishErr error
 	select {
-	case <-e.buildAbort:
-		log.Println(e.config.ShortDescription(), e.build.Id, "Build got aborted.")
-		buildState = Failed
-
-		if e.buildAbortFunc != nil {
-			e.buildAbortFunc(e)
-		}
-
 	case <-time.After(time.Duration(buildTimeout) * time.Second):
 		log.Println(e.config.ShortDescription(), e.build.Id, "Build timedout.")
-		buildState = Failed
-		buildMessage = fmt.Sprintf("\nCI Timeout. Execution took longer then %d seconds", buildTimeout)
+		e.abort(cancelTimeout)
+		finishErr = <-e.buildFinish
 
-		if e.buildAbortFunc != nil {
-			e.buildAbortFunc(e)
-		}
+	case <-e.ctx.Done():
+		log.Println(e.config.ShortDescription(), e.build.Id, "Build context cancelled.")
+		e.setCancelReason(cancelAbort)
+		finishErr = <-e.buildFinish
 
-	case err := <-e.buildFinish:
-		// command finished
-		if err != nil {
-			log.Println(e.config.ShortDescription(), e.build.Id, "Build failed with", err)
+	case finishErr = <-e.buildFinish:
+	}
+
+	if e.currentCancelReason() == cancelNone {
+		// The command finished on its own, without ever being aborted.
+		if finishErr != nil {
+			log.Println(e.config.ShortDescription(), e.build.Id, "Build failed with", finishErr)
 			buildState = Failed
-			buildMessage = fmt.Sprintf("\nBuild failed with %v", err)
+			buildMessage = fmt.Sprintf("\nBuild failed with %v", finishErr)
 		} else {
 			log.Println(e.config.ShortDescription(), e.build.Id, "Build succeeded.")
 			buildState = Success
 		}
+	} else {
+		// We got here through cancellation rather than a natural finish.
+		// Having already drained e.buildFinish above, the process/container
+		// is guaranteed to be gone by now, so Cleanup() is safe to delete the
+		// build log.
+		switch e.currentCancelReason() {
+		case cancelAbort:
+			buildState = Canceled
+			buildMessage = "\nBuild was cancelled."
+		case cancelTimeout:
+			buildState = TimedOut
+			buildMessage = fmt.Sprintf("\nCI Timeout. Execution took longer then %d seconds (partial output preserved)", buildTimeout)
+		}
 	}
 
+	// Cleanup() will transition into this state once it has actually torn
+	// down the process/container, so the debug listing never reports
+	// "finished" while teardown is still in progress.
+	e.pendingTerminalState = e.terminalState(buildState)
+
 	// wait for update log routine to finish
 	log.Debugln(e.config.ShortDescription(), e.build.Id, "Waiting for build log updater to finish")
 	e.buildLogFinish <- true
@@ -158,3 +352,33 @@ func (e *BaseExecutor) Wait() error {
 	e.build.FinishBuild(*e.config, buildState, buildMessage)
 	return nil
 }
+
+// WaitOrStop waits for cmd to finish, but if ctx is cancelled first it sends
+// interrupt to the process and gives it killDelay to exit gracefully before
+// force-killing it. It always waits for cmd.Wait() to return, so the caller
+// can be sure the process is gone once WaitOrStop returns.
+func WaitOrStop(ctx context.Context, cmd *exec.Cmd, interrupt os.Signal, killDelay time.Duration) error {
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitCh:
+		return err
+
+	case <-ctx.Done():
+		log.Debugln("Sending", interrupt, "to process", cmd.Process.Pid)
+		cmd.Process.Signal(interrupt)
+
+		select {
+		case err := <-waitCh:
+			return err
+
+		case <-time.After(killDelay):
+			log.Debugln("Process", cmd.Process.Pid, "did not stop after", killDelay, ", killing it")
+			cmd.Process.Kill()
+			return <-waitCh
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package src
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitOrStopLetsProcessExitOnItsOwn(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := WaitOrStop(ctx, cmd, syscall.SIGINT, time.Second); err != nil {
+		t.Fatalf("WaitOrStop() = %v, want nil", err)
+	}
+}
+
+func TestWaitOrStopInterruptsGracefulProcess(t *testing.T) {
+	// "sleep" terminates on the first SIGINT with no trap needed, so a fast
+	// return here proves the interrupt stopped it - a force-kill after the
+	// delay would also return an error, but only after killDelay elapses.
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	killDelay := time.Second
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := WaitOrStop(ctx, cmd, syscall.SIGINT, killDelay)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("WaitOrStop() = nil, want an error from being interrupted")
+	}
+	if elapsed >= killDelay {
+		t.Fatalf("WaitOrStop() took %v, expected SIGINT to stop the process well before the kill delay", elapsed)
+	}
+}
+
+func TestPollForCancelSignalsAbortWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tick := make(chan time.Time, 1)
+	abort := make(chan bool, 1)
+
+	calls := 0
+	isCanceled := func() bool {
+		calls++
+		return calls >= 3
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pollForCancel(ctx, tick, isCanceled, abort)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		tick <- time.Now()
+	}
+
+	select {
+	case <-abort:
+	case <-time.After(time.Second):
+		t.Fatal("pollForCancel did not push to abort after isCanceled returned true")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pollForCancel did not return after signalling abort")
+	}
+
+	if calls != 3 {
+		t.Fatalf("isCanceled called %d times, want 3", calls)
+	}
+}
+
+func TestPollForCancelStopsOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tick := make(chan time.Time)
+	abort := make(chan bool, 1)
+
+	done := make(chan struct{})
+	go func() {
+		pollForCancel(ctx, tick, func() bool { return false }, abort)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pollForCancel did not return after ctx was cancelled")
+	}
+
+	select {
+	case <-abort:
+		t.Fatal("pollForCancel pushed to abort despite never being cancelled by isCanceled")
+	default:
+	}
+}
+
+func TestWaitOrStopEscalatesToKillAfterDelay(t *testing.T) {
+	cmd := exec.Command("bash", "-c", "trap '' INT; sleep 5")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	killDelay := 100 * time.Millisecond
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := WaitOrStop(ctx, cmd, syscall.SIGINT, killDelay)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("WaitOrStop() = nil, want an error from being killed")
+	}
+	if elapsed < killDelay {
+		t.Fatalf("WaitOrStop() returned after %v, expected to wait out the kill delay (%v) first", elapsed, killDelay)
+	}
+}
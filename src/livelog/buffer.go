@@ -0,0 +1,248 @@
+// Package livelog provides an in-memory, multi-reader build log buffer.
+//
+// It replaces the old design of writing the log straight to a file and
+// having WatchTrace re-read the whole thing on every tick: writers append
+// to a bounded ring buffer, readers each track their own read offset and
+// block until more data arrives, and bytes that fall off the ring on
+// overflow are spilled to a small on-disk file so the final upload still
+// contains the complete log.
+package livelog
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// DefaultSize is the default in-memory ring buffer size, matching the
+// default most coordinators cap a single trace payload at.
+const DefaultSize = 2 * 1024 * 1024 // 2 MiB
+
+// ErrClosed is returned by Write once the buffer has been closed.
+var ErrClosed = errors.New("livelog: buffer closed")
+
+// Buffer is a concurrent-safe, bounded ring buffer of build output. Bytes
+// written past its capacity spill to an on-disk file so that total output
+// is never lost, only evicted from memory.
+type Buffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ring   []byte
+	size   int
+	offset int64 // total bytes ever written (monotonic)
+	closed bool
+
+	spillPath string
+	spill     *os.File
+}
+
+// New returns a Buffer with the given in-memory ring size. A size <= 0 uses
+// DefaultSize.
+func New(size int) *Buffer {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	b := &Buffer{
+		ring: make([]byte, 0, size),
+		size: size,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Write appends p to the buffer, spilling the oldest bytes to disk once the
+// in-memory ring is full. It implements io.Writer so the buffer can be used
+// anywhere a build log writer is expected.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return 0, ErrClosed
+	}
+
+	if len(p) > 0 {
+		b.ring = append(b.ring, p...)
+		if overflow := len(b.ring) - b.size; overflow > 0 {
+			// Only the prefix actually falling off the ring gets spilled -
+			// spilling all of p here would make Full() double-count
+			// whatever's still resident in the ring.
+			if err := b.spillLocked(b.ring[:overflow]); err != nil {
+				return 0, err
+			}
+			b.ring = b.ring[overflow:]
+		}
+		b.offset += int64(len(p))
+	}
+
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// spillLocked appends p to the on-disk overflow file, lazily creating it on
+// first use. Must be called with b.mu held.
+func (b *Buffer) spillLocked(p []byte) error {
+	if b.spill == nil {
+		f, err := ioutil.TempFile("", "build-log-")
+		if err != nil {
+			return err
+		}
+		b.spill = f
+		b.spillPath = f.Name()
+	}
+	_, err := b.spill.Write(p)
+	return err
+}
+
+// Offset returns the total number of bytes written so far.
+func (b *Buffer) Offset() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.offset
+}
+
+// Since returns the bytes written at or after offset that are still held in
+// memory, along with the new offset those bytes end at. It is used to build
+// offset-based incremental PATCH requests: callers only ever resend bytes
+// past the offset the coordinator last acknowledged.
+func (b *Buffer) Since(offset int64) ([]byte, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sinceLocked(offset)
+}
+
+// Full reconstructs the complete log by concatenating the spilled file (if
+// any) with whatever remains in the in-memory ring, for the final upload.
+func (b *Buffer) Full() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var spilled *os.File
+	if b.spillPath != "" {
+		f, err := os.Open(b.spillPath)
+		if err != nil {
+			return nil, err
+		}
+		spilled = f
+	}
+
+	tail := ioutil.NopCloser(newByteReader(b.ring))
+	if spilled == nil {
+		return tail, nil
+	}
+	return &multiReadCloser{readers: []io.Reader{spilled, tail}, closers: []io.Closer{spilled}}, nil
+}
+
+// NewReader returns a live, resumable reader starting at the given offset.
+// Read blocks until more data is written or the buffer is closed, at which
+// point it returns io.EOF once it has drained everything available.
+func (b *Buffer) NewReader(offset int64) io.Reader {
+	return &reader{buf: b, offset: offset}
+}
+
+// Close marks the buffer closed: writes after Close return ErrClosed, and
+// blocked readers are woken up to observe EOF. The on-disk spill file, if
+// any, is removed.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	spillPath := b.spillPath
+	spill := b.spill
+	b.cond.Broadcast()
+	b.mu.Unlock()
+
+	if spill != nil {
+		spill.Close()
+	}
+	if spillPath != "" {
+		return os.Remove(spillPath)
+	}
+	return nil
+}
+
+type reader struct {
+	buf    *Buffer
+	offset int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	r.buf.mu.Lock()
+	for {
+		chunk, newOffset := r.buf.sinceLocked(r.offset)
+		if len(chunk) > 0 {
+			r.buf.mu.Unlock()
+			n := copy(p, chunk)
+			// chunk starts past r.offset if those bytes were evicted from
+			// the ring, so advance from where chunk actually starts.
+			r.offset = newOffset - int64(len(chunk)) + int64(n)
+			return n, nil
+		}
+		if r.buf.closed {
+			r.buf.mu.Unlock()
+			return 0, io.EOF
+		}
+		r.offset = newOffset
+		r.buf.cond.Wait()
+	}
+}
+
+// sinceLocked is Since's body, split out so reader.Read can call it while
+// already holding b.mu (sync.Cond.Wait requires that invariant).
+func (b *Buffer) sinceLocked(offset int64) ([]byte, int64) {
+	ringStart := b.offset - int64(len(b.ring))
+	if offset < ringStart {
+		offset = ringStart
+	}
+	if offset >= b.offset {
+		return nil, b.offset
+	}
+	start := offset - ringStart
+	out := make([]byte, len(b.ring)-int(start))
+	copy(out, b.ring[start:])
+	return out, b.offset
+}
+
+func newByteReader(p []byte) io.Reader {
+	cp := make([]byte, len(p))
+	copy(cp, p)
+	return &byteReader{data: cp}
+}
+
+type byteReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+type multiReadCloser struct {
+	readers []io.Reader
+	closers []io.Closer
+	mr      io.Reader
+}
+
+func (m *multiReadCloser) Read(p []byte) (int, error) {
+	if m.mr == nil {
+		m.mr = io.MultiReader(m.readers...)
+	}
+	return m.mr.Read(p)
+}
+
+func (m *multiReadCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package livelog
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFullNoOverflow(t *testing.T) {
+	b := New(1024)
+	b.Write([]byte("AAAA"))
+
+	r, err := b.Full()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got, _ := ioutil.ReadAll(r)
+	if string(got) != "AAAA" {
+		t.Fatalf("Full() = %q, want %q", got, "AAAA")
+	}
+}
+
+func TestFullAcrossOverflow(t *testing.T) {
+	b := New(4)
+	b.Write([]byte("AAAA"))
+	b.Write([]byte("BBBB"))
+
+	r, err := b.Full()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got, _ := ioutil.ReadAll(r)
+	if string(got) != "AAAABBBB" {
+		t.Fatalf("Full() = %q, want %q", got, "AAAABBBB")
+	}
+}
+
+func TestSinceReturnsOnlyBytesAfterOffset(t *testing.T) {
+	b := New(1024)
+	b.Write([]byte("hello "))
+	b.Write([]byte("world"))
+
+	chunk, offset := b.Since(6)
+	if string(chunk) != "world" {
+		t.Fatalf("Since(6) = %q, want %q", chunk, "world")
+	}
+	if offset != 11 {
+		t.Fatalf("Since(6) offset = %d, want 11", offset)
+	}
+}
+
+func TestSinceClampsToEvictedOffset(t *testing.T) {
+	b := New(4)
+	b.Write([]byte("AAAA"))
+	b.Write([]byte("BBBB"))
+
+	// offset 0 has already been evicted from the ring; Since must clamp to
+	// what's still resident instead of returning bytes twice or panicking.
+	chunk, offset := b.Since(0)
+	if string(chunk) != "BBBB" {
+		t.Fatalf("Since(0) = %q, want %q", chunk, "BBBB")
+	}
+	if offset != 8 {
+		t.Fatalf("Since(0) offset = %d, want 8", offset)
+	}
+}
+
+func TestConcurrentReadersAndWriter(t *testing.T) {
+	b := New(1 << 20)
+
+	const chunks = 200
+	var want bytes.Buffer
+
+	var readerWg sync.WaitGroup
+	results := make([][]byte, 3)
+	for i := range results {
+		readerWg.Add(1)
+		go func(i int) {
+			defer readerWg.Done()
+			var got bytes.Buffer
+			io.Copy(&got, b.NewReader(0))
+			results[i] = got.Bytes()
+		}(i)
+	}
+
+	for i := 0; i < chunks; i++ {
+		line := []byte("line\n")
+		want.Write(line)
+		b.Write(line)
+	}
+	b.Close()
+
+	readerWg.Wait()
+	for i, got := range results {
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Fatalf("reader %d got %d bytes, want %d bytes", i, len(got), want.Len())
+		}
+	}
+}
+
+func TestSinceResumesFromAckedOffsetAcrossRetry(t *testing.T) {
+	b := New(1 << 20)
+	r := b.NewReader(0)
+
+	b.Write([]byte("first "))
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := string(buf[:n])
+
+	// Simulate a coordinator 5xx: the caller re-reads from the same offset
+	// it last acked instead of losing data.
+	ackedOffset := b.Offset()
+	b.Write([]byte("second"))
+
+	chunk, _ := b.Since(ackedOffset)
+	if string(chunk) != "second" {
+		t.Fatalf("Since(ackedOffset) = %q, want %q", chunk, "second")
+	}
+	if first != "first " {
+		t.Fatalf("initial read = %q, want %q", first, "first ")
+	}
+}
+
+func TestReaderBlocksThenEOFsOnClose(t *testing.T) {
+	b := New(1024)
+	r := b.NewReader(0)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Read returned before any data was written or the buffer closed")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.Close()
+
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Fatalf("Read after Close = %v, want io.EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not unblock after Close")
+	}
+}
+
+func TestWriteAfterCloseReturnsErrClosed(t *testing.T) {
+	b := New(1024)
+	b.Close()
+
+	if _, err := b.Write([]byte("x")); err != ErrClosed {
+		t.Fatalf("Write after Close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,79 @@
+package src
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type mockExecutor struct {
+	calls  []string
+	gotCtx context.Context
+}
+
+func (m *mockExecutor) Prepare(ctx context.Context, config *RunnerConfig, build *Build) error {
+	m.calls = append(m.calls, "Prepare")
+	m.gotCtx = ctx
+	return nil
+}
+
+func (m *mockExecutor) Start() error {
+	m.calls = append(m.calls, "Start")
+	return nil
+}
+
+func (m *mockExecutor) Wait() error {
+	m.calls = append(m.calls, "Wait")
+	return nil
+}
+
+func (m *mockExecutor) Cleanup() {
+	m.calls = append(m.calls, "Cleanup")
+}
+
+func TestRegisterExecutorRunsInOrderWithContext(t *testing.T) {
+	var created *mockExecutor
+	RegisterExecutor("mock-test", func(config RunnerConfig) Executor {
+		created = &mockExecutor{}
+		return created
+	})
+
+	config := RunnerConfig{Executor: "mock-test"}
+	executor := GetExecutor(config)
+	if executor == nil {
+		t.Fatal("GetExecutor() = nil, want the registered mock executor")
+	}
+
+	ctx := context.Background()
+	if err := executor.Prepare(ctx, &config, &Build{}); err != nil {
+		t.Fatalf("Prepare() = %v", err)
+	}
+	if err := executor.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := executor.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	executor.Cleanup()
+
+	want := []string{"Prepare", "Start", "Wait", "Cleanup"}
+	if !reflect.DeepEqual(created.calls, want) {
+		t.Fatalf("calls = %v, want %v", created.calls, want)
+	}
+	if created.gotCtx != ctx {
+		t.Fatalf("Prepare() received ctx %v, want the ctx passed in", created.gotCtx)
+	}
+}
+
+func TestGetExecutorDefaultsToShell(t *testing.T) {
+	executor := GetExecutor(RunnerConfig{})
+	if _, ok := executor.(*ShellExecutor); !ok {
+		t.Fatalf("GetExecutor(RunnerConfig{}) = %T, want *ShellExecutor", executor)
+	}
+}
+
+func TestGetExecutorUnknownNameReturnsNil(t *testing.T) {
+	if executor := GetExecutor(RunnerConfig{Executor: "does-not-exist"}); executor != nil {
+		t.Fatalf("GetExecutor() = %v, want nil for an unregistered executor name", executor)
+	}
+}
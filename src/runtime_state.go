@@ -0,0 +1,216 @@
+package src
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BuildRuntimeState describes where a build currently is in its lifecycle,
+// independent of the BuildState it eventually reports to the coordinator.
+// It exists so that something outside the executor - a status endpoint,
+// metrics, a future session server - can ask "what is this build doing
+// right now" instead of only learning the end result.
+type BuildRuntimeState int32
+
+const (
+	StatePending BuildRuntimeState = iota
+	StatePreparing
+	StateRunning
+	StateCleaning
+	StateFinished
+	StateCanceled
+	StateTimedOut
+	StateFailed
+)
+
+func (s BuildRuntimeState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StatePreparing:
+		return "preparing"
+	case StateRunning:
+		return "running"
+	case StateCleaning:
+		return "cleaning"
+	case StateFinished:
+		return "finished"
+	case StateCanceled:
+		return "canceled"
+	case StateTimedOut:
+		return "timed_out"
+	case StateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// setState atomically updates the runtime state and notifies subscribers.
+// Subscribers that aren't ready to receive are skipped rather than blocking
+// the build.
+func (e *BaseExecutor) setState(state BuildRuntimeState) {
+	atomic.StoreInt32(&e.runtimeState, int32(state))
+
+	e.subscribersMu.Lock()
+	for _, ch := range e.subscribers {
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+	e.subscribersMu.Unlock()
+
+	if state == StatePreparing {
+		registerActiveBuild(e)
+	}
+	if state == StateFinished || state == StateCanceled || state == StateTimedOut || state == StateFailed {
+		unregisterActiveBuild(e)
+	}
+}
+
+// CurrentState returns the build's current runtime state.
+func (e *BaseExecutor) CurrentState() BuildRuntimeState {
+	return BuildRuntimeState(atomic.LoadInt32(&e.runtimeState))
+}
+
+// Subscribe returns a channel that receives every runtime state transition
+// from this point on. The channel is closed once Cleanup() has run.
+func (e *BaseExecutor) Subscribe() <-chan BuildRuntimeState {
+	ch := make(chan BuildRuntimeState, 8)
+	e.subscribersMu.Lock()
+	e.subscribers = append(e.subscribers, ch)
+	e.subscribersMu.Unlock()
+	return ch
+}
+
+func (e *BaseExecutor) closeSubscribers() {
+	e.subscribersMu.Lock()
+	for _, ch := range e.subscribers {
+		close(ch)
+	}
+	e.subscribers = nil
+	e.subscribersMu.Unlock()
+}
+
+// terminalState maps the cancellation/outcome of a finished build to its
+// final runtime state, mirroring the BuildState derivation in Wait().
+func (e *BaseExecutor) terminalState(buildState BuildState) BuildRuntimeState {
+	switch e.currentCancelReason() {
+	case cancelAbort:
+		return StateCanceled
+	case cancelTimeout:
+		return StateTimedOut
+	}
+	if buildState == Failed {
+		return StateFailed
+	}
+	return StateFinished
+}
+
+var (
+	activeBuildsMu sync.Mutex
+	activeBuilds   = map[*BaseExecutor]time.Time{}
+)
+
+func registerActiveBuild(e *BaseExecutor) {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+	if _, ok := activeBuilds[e]; !ok {
+		activeBuilds[e] = time.Now()
+	}
+}
+
+func unregisterActiveBuild(e *BaseExecutor) {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+	delete(activeBuilds, e)
+}
+
+func findActiveBuild(id int) *BaseExecutor {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+	for e := range activeBuilds {
+		if e.build.Id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// ActiveBuild is a snapshot of one running build, used by the /debug/builds
+// handler.
+type ActiveBuild struct {
+	Description string
+	State       BuildRuntimeState
+	Runtime     time.Duration
+}
+
+func snapshotActiveBuilds() []ActiveBuild {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+
+	builds := make([]ActiveBuild, 0, len(activeBuilds))
+	for e, startedAt := range activeBuilds {
+		builds = append(builds, ActiveBuild{
+			Description: fmt.Sprintf("%s build %d", e.config.ShortDescription(), e.build.Id),
+			State:       e.CurrentState(),
+			Runtime:     time.Since(startedAt),
+		})
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].Description < builds[j].Description })
+	return builds
+}
+
+// RegisterDebugBuildsHandler mounts the /debug/builds endpoint, which lists
+// every build this runner is currently preparing, running or cleaning up,
+// along with its state and runtime. It is opt-in: the caller decides,
+// typically behind a command-line flag, whether to mount it at all.
+func RegisterDebugBuildsHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/builds", func(w http.ResponseWriter, r *http.Request) {
+		for _, build := range snapshotActiveBuilds() {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", build.Description, build.State, build.Runtime)
+		}
+	})
+}
+
+// RegisterBuildTraceHandler mounts /builds/<id>/trace, which streams a
+// build's live log to the client as it's written, e.g. `curl
+// .../builds/<id>/trace`. It reads from the same livelog.Buffer the build
+// itself writes to, so it never blocks the build and sees output as soon
+// as it's flushed.
+func RegisterBuildTraceHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/builds/", func(w http.ResponseWriter, r *http.Request) {
+		var id int
+		if n, err := fmt.Sscanf(r.URL.Path, "/builds/%d/trace", &id); err != nil || n != 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		e := findActiveBuild(id)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		reader := e.build_log.NewReader(0)
+		buf := make([]byte, 4096)
+		for {
+			n, err := reader.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
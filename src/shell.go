@@ -0,0 +1,34 @@
+package src
+
+import (
+	"bytes"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const shellKillDelay = 10 * time.Second
+
+// ShellExecutor runs the build script directly as a child process on the
+// host the runner is installed on.
+type ShellExecutor struct {
+	BaseExecutor
+
+	cmd *exec.Cmd
+}
+
+func (e *ShellExecutor) Start() error {
+	e.cmd = exec.Command("bash")
+	e.cmd.Stdin = bytes.NewReader(e.script_data)
+	e.cmd.Stdout = e.build_log
+	e.cmd.Stderr = e.build_log
+
+	if err := e.cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		e.buildFinish <- WaitOrStop(e.ctx, e.cmd, syscall.SIGINT, shellKillDelay)
+	}()
+	return nil
+}